@@ -1,6 +1,7 @@
 package nested
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -19,18 +20,34 @@ import (
 //
 // A Collection implements the Service interface.
 //
-// Services to be monitored are added using the Add() method.  Services cannot be removed once added.
+// Services to be monitored are added using the Add() method, or AddWithDeps() if the service depends on other
+// services already added to the collection.  Services cannot be removed once added.
 //
 // To start monitoring, the caller must invoke the Run() method.  Only when Run has been called AND all of the services
 // have finished initialization will the collection change its state.  Services should not be added after calling Run().
 //
+// A Collection can itself be added to another Collection, since it implements Service, forming a tree of composite
+// services (as in the Pub/Sub Lite "composite service" pattern).  State and errors propagate upward through the tree
+// automatically: a parent's state is derived from its children using the same aggregation rules, and a leaf error
+// surfaces at every ancestor's Err(), with the full label path from that ancestor down to the leaf (e.g.
+// "api/db/replica-2"), via CollectionError.  A nested Collection must have its own Run() called for its internal
+// aggregation to work, exactly as if it were the root.  Use Walk or FindByPath to introspect the tree.
+//
 // An empty Collection is ready to use and in the Initializing state.  A Collection must not be copied after first use.
 type Collection struct {
 	Monitor
 	sync.Mutex
-	services map[string]Service
-	running  bool
-	id       string // random id to distinguish this from other collections when registering observers
+	services     map[string]Service
+	deps         map[string][]string // label -> labels it depends on
+	running      bool
+	id           string // random id to distinguish this from other collections when registering observers
+	addCallbacks map[Token]func(label string, s Service)
+
+	// gates and stopGates are populated by Run() for every service present at the time; AddWithDeps adds a stop gate
+	// (but no ready gate) for any service added afterwards.  StopContext consults stopGates to sequence shutdown so
+	// that a service isn't stopped until everything depending on it has stopped.
+	gates     map[string]*gate // closed once a service first reaches Ready
+	stopGates map[string]*gate // closed once a service first reaches Stopped
 }
 
 // Verifies that a Collection implements the Service interface.
@@ -57,42 +74,206 @@ func (ce CollectionError) Error() string {
 	return strings.Join(msgs, "\n")
 }
 
-// Add adds a service to be monitored.  Panics if the label has already been used in this collection.
+// A ShutdownError is returned by Collection.StopContext when one or more services fail to stop before the given
+// context is done.  It can be inspected for which services were abandoned and why.
+type ShutdownError struct {
+	// Errors contains the error returned by StopContext for each service that failed to stop in time, indexed by
+	// label.  Only services that failed to stop are included.
+	Errors map[string]error
+}
+
+// Error returns the labels and errors of all services that failed to stop in time, in a multi-line string.
+func (se ShutdownError) Error() string {
+	msgs := make([]string, 0, len(se.Errors))
+	for id, err := range se.Errors {
+		msgs = append(msgs, id+": "+err.Error())
+	}
+	sort.Strings(msgs)
+	return "services failed to stop in time:\n" + strings.Join(msgs, "\n")
+}
+
+// Add adds a service to be monitored, with no dependency on any other service in the collection.  Panics if the
+// label has already been used in this collection.
 func (c *Collection) Add(label string, s Service) {
+	c.AddWithDeps(label, s)
+}
+
+// AddWithDeps adds a service to be monitored that depends on the services named in dependsOn, which must already
+// have been added to this collection.  When Run is called, the service is started (see Startable) only once every
+// one of dependsOn has reached Ready; when Stop is called, the service is not stopped until every service that
+// depends on it has stopped.  Panics if the label has already been used in this collection.
+func (c *Collection) AddWithDeps(label string, s Service, dependsOn ...string) {
 	c.Lock()
-	defer c.Unlock()
 
 	// Initialize the maps if this is the first service to be added.
 	if c.services == nil {
 		c.services = make(map[string]Service)
+		c.deps = make(map[string][]string)
 		c.id = strconv.FormatUint(rand.Uint64(), 16)
 	} else {
 		// Otherwise check that we're not reusing a label.
 		if _, ok := c.services[label]; ok {
+			c.Unlock()
 			panic(fmt.Sprintf("add: label %q already in use", label))
 		}
 	}
 
 	c.services[label] = s
+	c.deps[label] = append([]string(nil), dependsOn...)
 
-	// Just in case someone adds a service to a running collection, make sure we get its events.  The alternative would
-	// be to disallow adding the service in the first place, but we don't want to do that.
-	if c.running {
-		s.RegisterCallback(c.id, c.stateChanged)
+	// Just in case someone adds a service to a running collection, make sure we get its events, and give it its own
+	// stop gate so that StopContext can still sequence it correctly against whatever it depends on (or whatever gets
+	// added later depending on it). The alternative would be to disallow adding the service in the first place, but
+	// we don't want to do that.
+	running := c.running
+	var stopGate *gate
+	if running {
+		if c.stopGates == nil {
+			c.stopGates = make(map[string]*gate)
+		}
+		stopGate = newGate()
+		c.stopGates[label] = stopGate
+	}
+
+	addCallbacks := make([]func(string, Service), 0, len(c.addCallbacks))
+	for _, f := range c.addCallbacks {
+		addCallbacks = append(addCallbacks, f)
+	}
+	c.Unlock()
+
+	if running {
+		// Register before checking the current state so we can't miss a transition that races with that check.
+		s.RegisterCallback(func(ev Event) {
+			if ev.NewState == Stopped {
+				stopGate.close()
+			}
+			c.stateChanged(ev)
+		})
+		if s.GetState() == Stopped {
+			stopGate.close()
+		}
+	}
+
+	for _, f := range addCallbacks {
+		f(label, s)
+	}
+}
+
+// RegisterAddCallback registers f to be called once for every service currently in the collection, and again each
+// time a new service is added afterwards via Add or AddWithDeps.  This lets observers (such as the nested/metrics
+// exporter) attach their own per-service callbacks without polling.  Returns a token that can be used to
+// deregister it later.
+func (c *Collection) RegisterAddCallback(f func(label string, s Service)) Token {
+	c.Lock()
+	if c.addCallbacks == nil {
+		c.addCallbacks = make(map[Token]func(string, Service))
+	}
+
+	var token Token
+	for ok := true; ok; {
+		token = Token(rand.Uint32())
+		_, ok = c.addCallbacks[token]
+	}
+	c.addCallbacks[token] = f
+
+	services := make(map[string]Service, len(c.services))
+	for label, s := range c.services {
+		services[label] = s
+	}
+	c.Unlock()
+
+	for label, s := range services {
+		f(label, s)
 	}
+	return token
+}
+
+// DeregisterAddCallback removes a callback registered with RegisterAddCallback.  Does nothing if there is no
+// callback registered with the provided token.
+func (c *Collection) DeregisterAddCallback(token Token) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.addCallbacks, token)
 }
 
-// Run starts monitoring the added services.  The collection remains in the Initializing state until all of the
-// monitored services are finished initializing.
+// Run starts monitoring the added services in dependency order.  A service added via AddWithDeps is started (if it
+// implements Startable) only once every service it depends on has reached Ready; a plain Service that doesn't
+// implement Startable is assumed to be starting itself already, as described in the package doc comment.
+//
+// Run panics if the dependency graph contains a cycle.
+//
+// The collection remains in the Initializing state until all of the monitored services are finished initializing.
 //
 // Calling Run on an already running collection has no effect.
 func (c *Collection) Run() {
-	defer c.stateChanged(Event{})
 	c.Lock()
-	defer c.Unlock()
-	for _, s := range c.services {
-		s.RegisterCallback(c.id, c.stateChanged)
+	if c.running {
+		c.Unlock()
+		return
+	}
+	c.running = true
+
+	services := make(map[string]Service, len(c.services))
+	deps := make(map[string][]string, len(c.deps))
+	for label, s := range c.services {
+		services[label] = s
+		deps[label] = c.deps[label]
 	}
+	c.Unlock()
+
+	order := topoSort(deps)
+
+	gates := make(map[string]*gate, len(services))
+	stopGates := make(map[string]*gate, len(services))
+	for label := range services {
+		gates[label] = newGate()
+		stopGates[label] = newGate()
+	}
+
+	c.Lock()
+	c.gates = gates
+	c.stopGates = stopGates
+	c.Unlock()
+
+	// Register, for every service, a callback that closes its ready/stopped gates and feeds the aggregate
+	// stateChanged logic.  Registering before checking the current state (below) means we can't miss a
+	// transition that races with that check.
+	for label, s := range services {
+		label, s := label, s
+		s.RegisterCallback(func(ev Event) {
+			switch ev.NewState {
+			case Ready:
+				gates[label].close()
+			case Stopped:
+				stopGates[label].close()
+			}
+			c.stateChanged(ev)
+		})
+		switch s.GetState() {
+		case Ready:
+			gates[label].close()
+		case Stopped:
+			stopGates[label].close()
+		}
+	}
+
+	// Kick off each service's Start hook as soon as its dependencies are ready.  This runs in the background;
+	// Run() does not block waiting for the whole collection to become Ready.
+	for _, label := range order {
+		s, dependsOn := services[label], deps[label]
+		starter, ok := s.(Startable)
+		if !ok {
+			continue
+		}
+		go func() {
+			for _, dep := range dependsOn {
+				<-gates[dep].ch
+			}
+			starter.Start(context.Background())
+		}()
+	}
+
+	c.stateChanged(Event{})
 }
 
 // Up returns a map whose keys are the labels of all the currently monitored services and whose values are true if
@@ -107,25 +288,146 @@ func (c *Collection) Up() map[string]bool {
 	return up
 }
 
+// CompositeService is an alias for Collection, emphasizing that a Collection can be nested inside another to form a
+// tree of composite services; see the Collection doc comment, Walk and FindByPath.
+type CompositeService = Collection
+
+// Walk calls f once for every service directly or transitively monitored by c.  For a service that is itself a
+// *Collection, Walk also recurses into it.  path is the sequence of labels from c down to the service being
+// visited.  The order of sibling visits is unspecified except that it's consistent from one call to the next.
+func (c *Collection) Walk(f func(path []string, s Service)) {
+	c.Lock()
+	services := make(map[string]Service, len(c.services))
+	for label, s := range c.services {
+		services[label] = s
+	}
+	c.Unlock()
+
+	labels := make([]string, 0, len(services))
+	for label := range services {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		s := services[label]
+		f([]string{label}, s)
+		if child, ok := s.(*Collection); ok {
+			child.Walk(func(path []string, s Service) {
+				f(append([]string{label}, path...), s)
+			})
+		}
+	}
+}
+
+// FindByPath returns the service found by descending into nested Collections one label at a time, or nil if no
+// service exists at that path.
+func (c *Collection) FindByPath(path ...string) Service {
+	if len(path) == 0 {
+		return nil
+	}
+
+	c.Lock()
+	s, ok := c.services[path[0]]
+	c.Unlock()
+	if !ok {
+		return nil
+	}
+	if len(path) == 1 {
+		return s
+	}
+
+	child, ok := s.(*Collection)
+	if !ok {
+		return nil
+	}
+	return child.FindByPath(path[1:]...)
+}
+
 // Stop stops the collection and all monitored services and releases all of the resources.  Neither the collection nor
 // any of the services should be used after calling stop.
+//
+// If Run has been called, services are stopped in the reverse of their startup order: a service is not stopped
+// until every service that depends on it has stopped.  Otherwise, all services are stopped concurrently.
 func (c *Collection) Stop() {
+	c.StopContext(context.Background())
+}
 
-	// Initialize the wait group first so that wg.Wait() runs after the lock is released.  That way, if we block
-	// on any of the Stop() calls, we do so without holding the lock.
-	wg := sync.WaitGroup{}
-	defer wg.Wait()
+// StopContext stops the collection and all monitored services, like Stop, but bounds the wait on each service's own
+// StopContext with ctx.  Services that don't stop before ctx is done are abandoned (they keep running in the
+// background) and recorded in the returned ShutdownError; StopContext returns nil if every service stopped in time.
+func (c *Collection) StopContext(ctx context.Context) error {
 
 	c.Lock()
-	defer c.Unlock()
+	running := c.running
+	services := make(map[string]Service, len(c.services))
+	for label, s := range c.services {
+		services[label] = s
+	}
+	deps := make(map[string][]string, len(c.deps))
+	for label, dependsOn := range c.deps {
+		deps[label] = dependsOn
+	}
+	stopGates := make(map[string]*gate, len(c.stopGates))
+	for label, g := range c.stopGates {
+		stopGates[label] = g
+	}
+	c.Unlock()
+
+	var mu sync.Mutex
+	errs := make(map[string]error)
+	wg := sync.WaitGroup{}
+
+	stop := func(label string, s Service) {
+		defer wg.Done()
+		if err := s.StopContext(ctx); err != nil {
+			mu.Lock()
+			errs[label] = err
+			mu.Unlock()
+		}
+	}
+
+	wg.Add(len(services))
+	if !running {
+		for label, s := range services {
+			go stop(label, s)
+		}
+	} else {
+		// Run() gives every service present at the time a stop gate, and AddWithDeps does the same for any service
+		// added to a running collection afterwards (see its comment), so stopGates and deps between them cover every
+		// currently monitored service regardless of when it was added.
+		dependents := make(map[string][]string, len(services))
+		for label, dependsOn := range deps {
+			for _, dep := range dependsOn {
+				dependents[dep] = append(dependents[dep], label)
+			}
+		}
+
+		for label, s := range services {
+			label, s := label, s
+			waitFor := dependents[label]
+			go func() {
+				for _, dependent := range waitFor {
+					g := stopGates[dependent]
+					if g == nil {
+						continue // shouldn't happen, but don't hang waiting on a gate that was never created
+					}
+					select {
+					case <-g.ch:
+					case <-ctx.Done():
+					}
+				}
+				stop(label, s)
+			}()
+		}
+	}
 
-	wg.Add(len(c.services))
-	for _, service := range c.services {
-		go func(s Service) {
-			s.Stop()
-			wg.Done()
-		}(service)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return ShutdownError{Errors: errs}
 	}
+	return nil
 }
 
 // stateChanged updates the state of the collection according to the states of all of the monitored services.  No update is
@@ -150,7 +452,7 @@ func (c *Collection) stateChanged(_ Event) {
 		case Ready:
 			allStopped = false
 		case Error:
-			errs[id] = s.Err()
+			recordErrors(errs, id, s.Err())
 			allStopped = false // not actually needed, since we check for errors first
 		case Stopped:
 			anyStopped = true
@@ -174,3 +476,80 @@ func (c *Collection) stateChanged(_ Event) {
 
 	c.Monitor.SetReady()
 }
+
+// recordErrors adds err's contribution to errs under label.  If err is itself a CollectionError (because the
+// erroring service is a nested Collection), its errors are flattened into errs with their labels prefixed by
+// label+"/", recursively, so that a leaf error surfaces at every ancestor with its full path, e.g. "api/db/replica-2".
+func recordErrors(errs map[string]error, label string, err error) {
+	if ce, ok := err.(CollectionError); ok {
+		for sublabel, suberr := range ce.Errors {
+			recordErrors(errs, label+"/"+sublabel, suberr)
+		}
+		return
+	}
+	errs[label] = err
+}
+
+// a gate is a one-shot signal, closed at most once, used to let goroutines wait for a service to reach some state.
+type gate struct {
+	ch   chan struct{}
+	once sync.Once
+}
+
+func newGate() *gate {
+	return &gate{ch: make(chan struct{})}
+}
+
+func (g *gate) close() {
+	g.once.Do(func() { close(g.ch) })
+}
+
+// topoSort returns the labels of deps ordered so that every label appears after all of the labels it depends on.
+// It panics if deps contains an unknown dependency or a cycle.
+func topoSort(deps map[string][]string) []string {
+	const (
+		white = iota
+		gray
+		black
+	)
+
+	color := make(map[string]int, len(deps))
+	order := make([]string, 0, len(deps))
+	var path []string
+
+	var visit func(label string)
+	visit = func(label string) {
+		switch color[label] {
+		case black:
+			return
+		case gray:
+			cycle := append(append([]string{}, path...), label)
+			panic(fmt.Sprintf("nested: dependency cycle detected: %s", strings.Join(cycle, " -> ")))
+		}
+
+		color[label] = gray
+		path = append(path, label)
+		for _, dep := range deps[label] {
+			if _, ok := deps[dep]; !ok {
+				panic(fmt.Sprintf("nested: unknown dependency %q", dep))
+			}
+			visit(dep)
+		}
+		path = path[:len(path)-1]
+
+		color[label] = black
+		order = append(order, label)
+	}
+
+	// Visit in a deterministic order so that panics are reproducible.
+	labels := make([]string, 0, len(deps))
+	for label := range deps {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for _, label := range labels {
+		visit(label)
+	}
+	return order
+}
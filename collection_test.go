@@ -1,7 +1,10 @@
 package nested
 
 import (
+	"context"
 	"errors"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -61,3 +64,233 @@ func TestCollection(t *testing.T) {
 	co.Stop()
 	assertEqual(t, map[string]bool{"service 0": false, "service 1": false}, co.Up())
 }
+
+// startableMonitor is a Monitor that also implements Startable, recording whether Start has been called so tests
+// can verify when it happens relative to its dependencies.
+type startableMonitor struct {
+	Monitor
+	mu      sync.Mutex
+	started bool
+}
+
+func (s *startableMonitor) Start(_ context.Context) error {
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *startableMonitor) wasStarted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started
+}
+
+func TestCollectionRunStartsInDependencyOrder(t *testing.T) {
+	co := Collection{}
+	db, api := &startableMonitor{}, &startableMonitor{}
+	co.AddWithDeps("db", db)
+	co.AddWithDeps("api", api, "db")
+	co.Run()
+
+	// api depends on db, so it must not be started until db is ready.
+	time.Sleep(10 * time.Millisecond)
+	waitFor(t, time.Second, db.wasStarted)
+	if api.wasStarted() {
+		t.Fatal("api was started before its dependency db became ready")
+	}
+
+	db.SetReady()
+	waitFor(t, time.Second, api.wasStarted)
+}
+
+func TestCollectionRunPanicsOnCycle(t *testing.T) {
+	co := Collection{}
+	co.AddWithDeps("a", &Monitor{}, "b")
+	co.AddWithDeps("b", &Monitor{}, "a")
+
+	assertPanic(t, func() { co.Run() }, `nested: dependency cycle detected: a -> b -> a`)
+}
+
+func TestCollectionRunPanicsOnUnknownDependency(t *testing.T) {
+	co := Collection{}
+	co.AddWithDeps("api", &Monitor{}, "db")
+
+	assertPanic(t, func() { co.Run() }, `nested: unknown dependency "db"`)
+}
+
+func TestCollectionStopOrder(t *testing.T) {
+	co := Collection{}
+	db, api := &Monitor{}, &Monitor{}
+	co.AddWithDeps("db", db)
+	co.AddWithDeps("api", api, "db")
+	co.Run()
+	db.SetReady()
+	api.SetReady()
+	time.Sleep(10 * time.Millisecond)
+
+	stopped := make(chan string, 2)
+	db.RegisterCallback(func(ev Event) {
+		if ev.NewState == Stopped {
+			stopped <- "db"
+		}
+	})
+	api.RegisterCallback(func(ev Event) {
+		if ev.NewState == Stopped {
+			stopped <- "api"
+		}
+	})
+
+	co.Stop()
+
+	// api depends on db, so it must stop first.
+	assertEqual(t, "api", <-stopped)
+	assertEqual(t, "db", <-stopped)
+}
+
+// neverStoppingService is a Service whose StopContext never completes on its own; it only returns once ctx is done.
+type neverStoppingService struct {
+	Monitor
+}
+
+func (s *neverStoppingService) StopContext(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCollectionStopContextReportsUnstoppedServices(t *testing.T) {
+	co := Collection{}
+	co.Add("slow", &neverStoppingService{})
+	co.Run()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := co.StopContext(ctx)
+	shutdownErr, ok := err.(ShutdownError)
+	if !ok {
+		t.Fatalf("expected a ShutdownError, got %v (%T)", err, err)
+	}
+	if _, ok := shutdownErr.Errors["slow"]; !ok {
+		t.Fatalf("expected an error for %q, got %v", "slow", shutdownErr.Errors)
+	}
+}
+
+// TestCollectionStopContextLateAddedService is a regression test: a service added after Run() was not present when
+// Run() set up the stop gates, so StopContext must still stop it (and count it in the WaitGroup) rather than hanging
+// forever.
+func TestCollectionStopContextLateAddedService(t *testing.T) {
+	co := Collection{}
+	co.Add("service 0", &Monitor{})
+	co.Run()
+
+	co.Add("service 1", &Monitor{})
+
+	done := make(chan struct{})
+	go func() {
+		co.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop did not return after a service was added to a running collection")
+	}
+}
+
+// delayedStopService sleeps for delay before actually stopping, so tests can observe whether something else
+// finished stopping before it did.
+type delayedStopService struct {
+	Monitor
+	delay time.Duration
+}
+
+func (d *delayedStopService) StopContext(ctx context.Context) error {
+	time.Sleep(d.delay)
+	return d.Monitor.StopContext(ctx)
+}
+
+// TestCollectionStopContextLateAddedDependentBlocksItsDependency is a regression test: a dependent added via
+// AddWithDeps after Run() must still be stopped before the dependency it names, even though that dependency was
+// already running before the dependent was ever added.
+func TestCollectionStopContextLateAddedDependentBlocksItsDependency(t *testing.T) {
+	co := Collection{}
+	a := &Monitor{}
+	co.Add("a", a)
+	co.Run()
+	a.SetReady()
+
+	b := &delayedStopService{delay: 50 * time.Millisecond}
+	co.AddWithDeps("b", b, "a")
+	b.SetReady()
+
+	aStoppedAt := make(chan time.Time, 1)
+	a.RegisterCallback(func(ev Event) {
+		if ev.NewState == Stopped {
+			aStoppedAt <- time.Now()
+		}
+	})
+
+	start := time.Now()
+	co.Stop()
+
+	select {
+	case stoppedAt := <-aStoppedAt:
+		if stoppedAt.Sub(start) < b.delay {
+			t.Fatalf("a stopped after %v, before its late-added dependent b (delay %v) finished stopping", stoppedAt.Sub(start), b.delay)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a never stopped")
+	}
+}
+
+func TestCollectionNestingPropagatesStateAndFlattensErrors(t *testing.T) {
+	root := Collection{}
+	api := &CompositeService{}
+
+	db := &Monitor{}
+	api.Add("db", db)
+	root.Add("api", api)
+
+	api.Run()
+	root.Run()
+	time.Sleep(10 * time.Millisecond)
+	assertEqual(t, Initializing, root.GetState())
+
+	db.SetReady()
+	time.Sleep(10 * time.Millisecond)
+	assertEqual(t, Ready, root.GetState())
+	assertEqual(t, nil, root.Err())
+
+	dbErr := errors.New("replica down")
+	db.SetError(dbErr)
+	time.Sleep(10 * time.Millisecond)
+	assertEqual(t, Error, root.GetState())
+	assertEqual(t, error(CollectionError{Errors: map[string]error{"api/db": dbErr}}), root.Err())
+}
+
+func TestCollectionWalkAndFindByPath(t *testing.T) {
+	root := Collection{}
+	api := &Collection{}
+	db := &Monitor{}
+
+	api.Add("db", db)
+	root.Add("api", api)
+
+	visited := make(map[string]Service)
+	root.Walk(func(path []string, s Service) {
+		visited[strings.Join(path, "/")] = s
+	})
+
+	if visited["api"] != Service(api) {
+		t.Fatalf("expected Walk to visit api, got %v", visited["api"])
+	}
+	if visited["api/db"] != Service(db) {
+		t.Fatalf("expected Walk to visit api/db, got %v", visited["api/db"])
+	}
+
+	assertEqual(t, Service(db), root.FindByPath("api", "db"))
+	assertEqual(t, Service(nil), root.FindByPath("api", "missing"))
+	assertEqual(t, Service(nil), root.FindByPath("missing"))
+}
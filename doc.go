@@ -29,4 +29,33 @@
 //
 // The MyService constructor may either return an initializing service or a fully initialized service.  The MyService
 // Stop() method, however, should always wait until the service has stopped completely before returning.
+//
+// A Supervisor wraps a ServiceFactory and restarts the service it produces whenever it enters the Error state,
+// pausing for FailureBackoff before a restart once failures recur faster than FailureThreshold allows.  The pause is
+// the same fixed FailureBackoff every time; it does not grow with repeated backoffs.  Since a Supervisor itself implements
+// Service, it can be nested inside a Collection like any other monitored service.
+//
+// A Collection monitors several services together; see the Collection doc comment for how its overall state is
+// derived from theirs.  Services added with AddWithDeps are started and stopped in dependency order: Collection.Run
+// starts each one, via its Startable.Start hook if it has one, only once everything it depends on is Ready, and
+// Collection.Stop reverses that order so a service outlives whatever depends on it.
+//
+// Every Service also has a context-aware StopContext, used by callers that want to bound how long shutdown can
+// take; Monitor.ShutdownTimeout places the same bound on a Monitor-based service regardless of the context it's
+// given.  Collection.StopContext applies a ctx to the whole tree and reports which services, if any, didn't stop in
+// time as a ShutdownError.
+//
+// Monitor.SetProbe turns a Monitor into an actively self-checking service: it runs a Probe (a TCP dial, an HTTP GET,
+// or a user-supplied ping func) on a jittered interval, requiring FailureThreshold consecutive failures before
+// flipping to Error and SuccessThreshold consecutive successes before flipping back to Ready, so a flaky check
+// doesn't flap the service's state.
+//
+// Because a Collection implements Service, collections nest: adding one Collection to another builds a tree of
+// composite services (CompositeService is an alias for Collection, for readers thinking in those terms), with
+// state and errors propagating automatically from leaf to root.  An error from a deeply nested service is reported
+// at every ancestor's Err() as a CollectionError keyed by the full label path, e.g. "api/db/replica-2".  Walk and
+// FindByPath introspect the tree.
+//
+// The nested/metrics subpackage exports a Collection's state as Prometheus or OpenTelemetry metrics; see its doc
+// comment.
 package nested
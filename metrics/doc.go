@@ -0,0 +1,7 @@
+// Package metrics exports the state of nested.Collection services as metrics, so that operators get the same
+// observability surface from this library that they'd expect from Tendermint's service base or similar.
+//
+// Register publishes Prometheus metrics; RegisterOTel publishes the OpenTelemetry equivalent.  Both attach a
+// callback (via Collection.RegisterAddCallback and Service.RegisterCallback) to every service currently in the
+// collection and to any service added afterwards, rather than polling.
+package metrics
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	nested "github.com/travelaudience/go-nested"
+)
+
+// An OTelExporter publishes the state of a nested.Collection's services as OpenTelemetry metrics.  Create one with
+// RegisterOTel.
+type OTelExporter struct {
+	transitions metric.Int64Counter
+	errors      metric.Int64Counter
+	duration    metric.Float64Histogram
+
+	mu         sync.Mutex
+	lastChange map[string]time.Time
+	states     map[string]nested.State
+	logger     func(nested.Event)
+}
+
+// RegisterOTel instruments c the same way Register does, using OpenTelemetry instruments instead of Prometheus
+// collectors: an observable gauge for current state, and counters/a histogram for transitions, errors, and time
+// spent per state.  It attaches to every service currently in c and to any service added to c later.
+func RegisterOTel(meter metric.Meter, c *nested.Collection) (*OTelExporter, error) {
+	transitions, err := meter.Int64Counter("nested.service.state_transitions",
+		metric.WithDescription("Total number of state transitions observed for a nested service."))
+	if err != nil {
+		return nil, err
+	}
+
+	errs, err := meter.Int64Counter("nested.service.errors",
+		metric.WithDescription("Total number of Error events from a nested service, including repeats while already in Error."))
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := meter.Float64Histogram("nested.service.state_duration",
+		metric.WithDescription("Time a nested service spent in a state before transitioning out of it."),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+
+	e := &OTelExporter{
+		transitions: transitions,
+		errors:      errs,
+		duration:    duration,
+		lastChange:  make(map[string]time.Time),
+		states:      make(map[string]nested.State),
+	}
+
+	_, err = meter.Int64ObservableGauge("nested.service.state",
+		metric.WithDescription("1 if the labeled service is currently in the labeled state, 0 otherwise."),
+		metric.WithInt64Callback(e.observeStates))
+	if err != nil {
+		return nil, err
+	}
+
+	c.RegisterAddCallback(e.watch)
+	return e, nil
+}
+
+// SetLogger registers f to be called with every state-change Event observed by the exporter, across all of the
+// collection's services, so callers can wire up slog, zap, or similar without writing their own RegisterCallback
+// plumbing.
+func (e *OTelExporter) SetLogger(f func(nested.Event)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.logger = f
+}
+
+func (e *OTelExporter) watch(label string, s nested.Service) {
+	e.mu.Lock()
+	e.lastChange[label] = time.Now()
+	e.mu.Unlock()
+
+	// Register before checking the current state so we can't miss a transition that races with that check.
+	s.RegisterCallback(func(ev nested.Event) { e.onEvent(label, ev) })
+
+	e.mu.Lock()
+	e.states[label] = s.GetState()
+	e.mu.Unlock()
+}
+
+func (e *OTelExporter) onEvent(label string, ev nested.Event) {
+	e.mu.Lock()
+	last := e.lastChange[label]
+	now := time.Now()
+	e.lastChange[label] = now
+	e.states[label] = ev.NewState
+	logger := e.logger
+	e.mu.Unlock()
+
+	ctx := context.Background()
+	e.duration.Record(ctx, now.Sub(last).Seconds(),
+		metric.WithAttributes(attribute.String("service", label), attribute.String("state", ev.OldState.String())))
+	e.transitions.Add(ctx, 1, metric.WithAttributes(attribute.String("service", label)))
+	if ev.NewState == nested.Error {
+		e.errors.Add(ctx, 1, metric.WithAttributes(attribute.String("service", label)))
+	}
+
+	if logger != nil {
+		logger(ev)
+	}
+}
+
+func (e *OTelExporter) observeStates(_ context.Context, o metric.Int64Observer) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for label, state := range e.states {
+		for _, st := range allStates {
+			v := int64(0)
+			if st == state {
+				v = 1
+			}
+			o.Observe(v, metric.WithAttributes(attribute.String("service", label), attribute.String("state", st.String())))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	nested "github.com/travelaudience/go-nested"
+)
+
+func collect(t *testing.T, reader *sdkmetric.ManualReader) metricdata.ResourceMetrics {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	return rm
+}
+
+func findMetric(rm metricdata.ResourceMetrics, name string) (metricdata.Metrics, bool) {
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name == name {
+				return m, true
+			}
+		}
+	}
+	return metricdata.Metrics{}, false
+}
+
+func TestRegisterOTelObservesStateAndTransitions(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	meter := provider.Meter("nested/metrics_test")
+
+	c := &nested.Collection{}
+	s := &nested.Monitor{}
+	c.Add("db", s)
+	c.Run()
+
+	if _, err := RegisterOTel(meter, c); err != nil {
+		t.Fatalf("RegisterOTel: %v", err)
+	}
+
+	s.SetReady()
+	s.SetError(errors.New("boom"))
+
+	rm := collect(t, reader)
+
+	if _, ok := findMetric(rm, "nested.service.state"); !ok {
+		t.Fatal("expected nested.service.state to be exported")
+	}
+
+	m, ok := findMetric(rm, "nested.service.state_transitions")
+	if !ok {
+		t.Fatal("expected nested.service.state_transitions to be exported")
+	}
+	sum, ok := m.Data.(metricdata.Sum[int64])
+	if !ok || len(sum.DataPoints) != 1 || sum.DataPoints[0].Value != 2 {
+		t.Fatalf("expected a single transitions data point with value 2, got %#v", m.Data)
+	}
+
+	m, ok = findMetric(rm, "nested.service.errors")
+	if !ok {
+		t.Fatal("expected nested.service.errors to be exported")
+	}
+	sum, ok = m.Data.(metricdata.Sum[int64])
+	if !ok || len(sum.DataPoints) != 1 || sum.DataPoints[0].Value != 1 {
+		t.Fatalf("expected a single errors data point with value 1, got %#v", m.Data)
+	}
+}
+
+func TestRegisterOTelSetLogger(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	meter := provider.Meter("nested/metrics_test")
+
+	c := &nested.Collection{}
+	s := &nested.Monitor{}
+	c.Add("db", s)
+	c.Run()
+
+	e, err := RegisterOTel(meter, c)
+	if err != nil {
+		t.Fatalf("RegisterOTel: %v", err)
+	}
+
+	var got nested.Event
+	e.SetLogger(func(ev nested.Event) { got = ev })
+
+	s.SetReady()
+	if got.NewState != nested.Ready {
+		t.Fatalf("expected the logger to observe a transition to Ready, got %+v", got)
+	}
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	nested "github.com/travelaudience/go-nested"
+)
+
+var allStates = []nested.State{nested.Initializing, nested.Ready, nested.Error, nested.Stopped}
+
+// An Exporter publishes the state of a nested.Collection's services as Prometheus metrics.  Create one with
+// Register.
+type Exporter struct {
+	state       *prometheus.GaugeVec
+	transitions *prometheus.CounterVec
+	errors      *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+
+	mu         sync.Mutex
+	lastChange map[string]time.Time
+	logger     func(nested.Event)
+}
+
+// Register attaches Prometheus collectors to reg that expose the state of every service in c, including any added
+// to c later.  It publishes:
+//   - nested_service_state: a gauge, labeled by service and state, set to 1 for a service's current state and 0 for
+//     the other three.
+//   - nested_service_state_transitions_total: a counter of state transitions, labeled by service.
+//   - nested_service_errors_total: a counter incremented on every Error event from a service, including a repeated
+//     SetError while the service is already in the Error state.
+//   - nested_service_state_duration_seconds: a histogram of time spent in a state before transitioning out of it.
+func Register(reg prometheus.Registerer, c *nested.Collection) (*Exporter, error) {
+	e := &Exporter{
+		state: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "nested",
+			Name:      "service_state",
+			Help:      "1 if the labeled service is currently in the labeled state, 0 otherwise.",
+		}, []string{"service", "state"}),
+		transitions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nested",
+			Name:      "service_state_transitions_total",
+			Help:      "Total number of state transitions observed for the labeled service.",
+		}, []string{"service"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nested",
+			Name:      "service_errors_total",
+			Help:      "Total number of Error events from the labeled service, including repeats while already in Error.",
+		}, []string{"service"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "nested",
+			Name:      "service_state_duration_seconds",
+			Help:      "Time the labeled service spent in the labeled state before transitioning out of it.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"service", "state"}),
+		lastChange: make(map[string]time.Time),
+	}
+
+	for _, collector := range []prometheus.Collector{e.state, e.transitions, e.errors, e.duration} {
+		if err := reg.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	c.RegisterAddCallback(e.watch)
+	return e, nil
+}
+
+// SetLogger registers f to be called with every state-change Event observed by the exporter, across all of the
+// collection's services, so callers can wire up slog, zap, or similar without writing their own RegisterCallback
+// plumbing.
+func (e *Exporter) SetLogger(f func(nested.Event)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.logger = f
+}
+
+func (e *Exporter) watch(label string, s nested.Service) {
+	e.mu.Lock()
+	e.lastChange[label] = time.Now()
+	e.mu.Unlock()
+
+	// Register before checking the current state so we can't miss a transition that races with that check.
+	s.RegisterCallback(func(ev nested.Event) { e.onEvent(label, ev) })
+	e.setState(label, s.GetState())
+}
+
+func (e *Exporter) onEvent(label string, ev nested.Event) {
+	e.mu.Lock()
+	last := e.lastChange[label]
+	now := time.Now()
+	e.lastChange[label] = now
+	logger := e.logger
+	e.mu.Unlock()
+
+	e.duration.WithLabelValues(label, ev.OldState.String()).Observe(now.Sub(last).Seconds())
+	e.setState(label, ev.NewState)
+	e.transitions.WithLabelValues(label).Inc()
+	if ev.NewState == nested.Error {
+		e.errors.WithLabelValues(label).Inc()
+	}
+
+	if logger != nil {
+		logger(ev)
+	}
+}
+
+func (e *Exporter) setState(label string, state nested.State) {
+	for _, st := range allStates {
+		v := 0.0
+		if st == state {
+			v = 1
+		}
+		e.state.WithLabelValues(label, st.String()).Set(v)
+	}
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	nested "github.com/travelaudience/go-nested"
+)
+
+func TestRegisterTracksStateGaugeAndTransitions(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := &nested.Collection{}
+	s := &nested.Monitor{}
+	c.Add("db", s)
+	c.Run()
+
+	e, err := Register(reg, c)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	assertGaugeValue(t, e, "db", "initializing", 1)
+	assertGaugeValue(t, e, "db", "ready", 0)
+
+	s.SetReady()
+	assertGaugeValue(t, e, "db", "initializing", 0)
+	assertGaugeValue(t, e, "db", "ready", 1)
+	if got := testutil.ToFloat64(e.transitions.WithLabelValues("db")); got != 1 {
+		t.Fatalf("expected 1 transition, got %v", got)
+	}
+
+	s.SetError(errors.New("boom"))
+	assertGaugeValue(t, e, "db", "ready", 0)
+	assertGaugeValue(t, e, "db", "error", 1)
+	if got := testutil.ToFloat64(e.transitions.WithLabelValues("db")); got != 2 {
+		t.Fatalf("expected 2 transitions, got %v", got)
+	}
+	if got := testutil.ToFloat64(e.errors.WithLabelValues("db")); got != 1 {
+		t.Fatalf("expected 1 error, got %v", got)
+	}
+}
+
+func TestRegisterObservesServicesAddedAfterRegister(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := &nested.Collection{}
+	c.Run()
+
+	e, err := Register(reg, c)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	cache := &nested.Monitor{}
+	c.Add("cache", cache)
+	assertGaugeValue(t, e, "cache", "initializing", 1)
+
+	cache.SetReady()
+	assertGaugeValue(t, e, "cache", "ready", 1)
+}
+
+func TestRegisterSetLogger(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := &nested.Collection{}
+	s := &nested.Monitor{}
+	c.Add("db", s)
+	c.Run()
+
+	e, err := Register(reg, c)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	var got nested.Event
+	e.SetLogger(func(ev nested.Event) { got = ev })
+
+	s.SetReady()
+	if got.NewState != nested.Ready {
+		t.Fatalf("expected the logger to observe a transition to Ready, got %+v", got)
+	}
+}
+
+func assertGaugeValue(t *testing.T, e *Exporter, service, state string, want float64) {
+	t.Helper()
+	if got := testutil.ToFloat64(e.state.WithLabelValues(service, state)); got != want {
+		t.Fatalf("nested_service_state{service=%q,state=%q}: want %v, got %v", service, state, want, got)
+	}
+}
@@ -1,9 +1,11 @@
 package nested
 
 import (
+	"context"
 	"math"
 	"math/rand"
 	"sync"
+	"time"
 )
 
 // A Monitor is a basic implementation of the nested service finite state machine.
@@ -15,6 +17,19 @@ type Monitor struct {
 	err       error // current error state, if the state is not ready
 	errCount  int   // number of consecutive errors
 	callbacks map[Token]func(Event)
+	probeStop chan struct{} // closed by SetProbe (replacing a previous probe) or by setState on reaching Stopped
+
+	// ShutdownTimeout bounds how long StopContext will wait for Stop to finish, in addition to whatever deadline the
+	// caller's context already carries.  Zero (the default) means StopContext is bounded only by the caller's
+	// context.
+	ShutdownTimeout time.Duration
+
+	// FailureThreshold is the number of consecutive probe failures, set via SetProbe, required before the Monitor
+	// is flipped to Error.  Zero or negative is treated as 1.
+	FailureThreshold int
+	// SuccessThreshold is the number of consecutive probe successes, set via SetProbe, required before the Monitor
+	// is flipped back to Ready.  Zero or negative is treated as 1.
+	SuccessThreshold int
 }
 
 // Verifies that a Monitor implements the Service interface.  Note that the Service interface does NOT include the
@@ -49,6 +64,17 @@ func (m *Monitor) Stop() {
 	m.setState(Stopped, nil)
 }
 
+// StopContext stops the service, like Stop, but returns early with ctx.Err() if Stop hasn't finished before ctx (or
+// the Monitor's ShutdownTimeout, whichever comes first) is done.  Stop keeps running in the background regardless.
+func (m *Monitor) StopContext(ctx context.Context) error {
+	if m.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.ShutdownTimeout)
+		defer cancel()
+	}
+	return WaitForStop(ctx, m.Stop)
+}
+
 // RegisterCallback registers a function which will be called any time there is a state change.  Returns a token that
 // can be used to deregister it later.
 func (m *Monitor) RegisterCallback(f func(Event)) Token {
@@ -126,6 +152,12 @@ func (m *Monitor) setState(newState State, newErr error) {
 		m.err = newErr
 	}
 
+	// Let a running probe goroutine (if any) exit immediately rather than waiting for its next tick.
+	if newState == Stopped && m.probeStop != nil {
+		close(m.probeStop)
+		m.probeStop = nil
+	}
+
 	// Notify all observers.
 	wg.Add(len(m.callbacks))
 	for _, cb := range m.callbacks {
@@ -1,5 +1,7 @@
 package nested
 
+import "context"
+
 type State int8
 
 const (
@@ -25,6 +27,7 @@ type Event struct {
 	OldState State
 	NewState State
 	Error    error // error condition if the new state is Error, nil otherwise
+	ErrCount int   // number of consecutive Error states as of this event; 0 if NewState is not Error
 }
 
 // The Service interface defines the behavior of a nested service.
@@ -37,6 +40,10 @@ type Service interface {
 	ErrCount() int
 	// Stop stops the service and releases all resources.  Stop should not return until the service shutdown is complete.
 	Stop()
+	// StopContext stops the service, like Stop, but gives up and returns ctx.Err() (typically
+	// context.DeadlineExceeded) if the service hasn't finished stopping before ctx is done.  Implementations that
+	// can't interrupt their own shutdown can use WaitForStop to adapt a blocking Stop method.
+	StopContext(ctx context.Context) error
 	// RegisterCallback registers a function which will be called any time there is a state change.  Returns a token
 	// that can be used to deregister it later.
 	RegisterCallback(f func(Event)) Token
@@ -44,5 +51,40 @@ type Service interface {
 	DeregisterCallback(Token)
 }
 
+// WaitForStop adapts a blocking, context-unaware Stop method into a StopContext implementation: it runs stop in the
+// background and returns nil once stop returns, or ctx.Err() if ctx is done first (stop keeps running regardless).
+// Implementations that embed Monitor get this behavior for free via Monitor.StopContext; implementations that
+// override Stop() need to call WaitForStop themselves, e.g.
+//
+//	func (s *MyService) StopContext(ctx context.Context) error {
+//	    return nested.WaitForStop(ctx, s.Stop)
+//	}
+func WaitForStop(ctx context.Context, stop func()) error {
+	done := make(chan struct{})
+	go func() {
+		stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // A Token identifies a registered callback so that it can later be deregistered.
 type Token uint32
+
+// A Startable service can be started explicitly once its dependencies are ready.  Collection.Run calls Start on
+// any added service that implements Startable, but only after every service it depends on (as registered via
+// AddWithDeps) has reached the Ready state.
+//
+// A Service that does not implement Startable is assumed to already be starting itself as soon as it is
+// constructed, per the convention described in the package doc comment.
+type Startable interface {
+	// Start starts the service.  Start should not block waiting for the service to become Ready; the service
+	// reports that through the normal Service state machine.
+	Start(ctx context.Context) error
+}
@@ -0,0 +1,131 @@
+package nested
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// A Probe is a health check invoked periodically by Monitor.SetProbe.  A non-nil error indicates the check failed;
+// the probe should respect ctx's deadline.
+type Probe func(ctx context.Context) error
+
+// SetProbe starts a background goroutine that invokes probe roughly every interval (jittered by up to 20% so that
+// many monitored services don't all probe in lockstep) until the Monitor reaches Stopped.  A probe failure doesn't
+// flip the Monitor to Error until FailureThreshold consecutive failures have been observed, and a subsequent
+// recovery doesn't flip it back to Ready until SuccessThreshold consecutive successes have been observed; this
+// debounced model mirrors go-micro's Debug.Health checks.
+//
+// SetProbe may be called before or after the Monitor has otherwise been used, and again to replace a previously set
+// probe, which stops the earlier probe's goroutine.
+func (m *Monitor) SetProbe(interval time.Duration, probe Probe) {
+	m.Lock()
+	if m.probeStop != nil {
+		close(m.probeStop)
+	}
+	stop := make(chan struct{})
+	m.probeStop = stop
+	m.Unlock()
+
+	go m.runProbe(interval, probe, stop)
+}
+
+func (m *Monitor) runProbe(interval time.Duration, probe Probe, stop chan struct{}) {
+	failureThreshold := m.FailureThreshold
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	successThreshold := m.SuccessThreshold
+	if successThreshold < 1 {
+		successThreshold = 1
+	}
+
+	var failures, successes int
+	timer := time.NewTimer(jitter(interval))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+
+		if m.GetState() == Stopped {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := probe(ctx)
+		cancel()
+
+		if err != nil {
+			successes = 0
+			failures++
+			if failures >= failureThreshold {
+				m.SetError(err)
+			}
+		} else {
+			failures = 0
+			successes++
+			if successes >= successThreshold {
+				m.SetReady()
+			}
+		}
+
+		timer.Reset(jitter(interval))
+	}
+}
+
+// jitter returns interval adjusted by a random amount within +/-20%, so that many Monitors started at the same time
+// don't all probe in lockstep.
+func jitter(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	spread := interval / 5
+	return interval - spread + time.Duration(rand.Int63n(int64(2*spread+1)))
+}
+
+// TCPProbe returns a Probe that succeeds if it can open and immediately close a TCP connection to address before
+// ctx is done.
+func TCPProbe(address string) Probe {
+	return func(ctx context.Context) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// HTTPProbe returns a Probe that succeeds if an HTTP GET to url returns a 2xx status before ctx is done.
+func HTTPProbe(url string) Probe {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("nested: probe: %s: unexpected status %s", url, resp.Status)
+		}
+		return nil
+	}
+}
+
+// PingProbe adapts a simple, context-unaware health check function into a Probe, for callers whose health check
+// doesn't need to be cancelled early.
+func PingProbe(ping func() error) Probe {
+	return func(ctx context.Context) error {
+		return ping()
+	}
+}
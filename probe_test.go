@@ -0,0 +1,117 @@
+package nested
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scriptedProbe returns results[0], results[1], ... on successive calls, and nil once it runs out of scripted
+// results, so tests can assert on SetProbe's debouncing at specific call counts.
+type scriptedProbe struct {
+	mu      sync.Mutex
+	results []error
+	calls   int
+}
+
+func (p *scriptedProbe) probe(_ context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var err error
+	if p.calls < len(p.results) {
+		err = p.results[p.calls]
+	}
+	p.calls++
+	return err
+}
+
+func (p *scriptedProbe) callCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func TestMonitorSetProbeDebouncesFailuresAndSuccesses(t *testing.T) {
+	sp := &scriptedProbe{results: []error{errors.New("down"), errors.New("down"), nil, nil}}
+
+	m := &Monitor{FailureThreshold: 2, SuccessThreshold: 2}
+	m.SetReady()
+	m.SetProbe(20*time.Millisecond, sp.probe)
+	defer m.Stop()
+
+	// A single failure isn't enough to flip the state to Error.
+	waitFor(t, time.Second, func() bool { return sp.callCount() >= 1 })
+	assertEqual(t, Ready, m.GetState())
+
+	// A second consecutive failure is.
+	waitFor(t, time.Second, func() bool { return m.GetState() == Error })
+
+	// A single success isn't enough to recover.
+	waitFor(t, time.Second, func() bool { return sp.callCount() >= 3 })
+	assertEqual(t, Error, m.GetState())
+
+	// A second consecutive success is.
+	waitFor(t, time.Second, func() bool { return m.GetState() == Ready })
+}
+
+func TestMonitorSetProbeReplacesPreviousProbe(t *testing.T) {
+	sp1 := &scriptedProbe{}
+	sp2 := &scriptedProbe{}
+
+	m := &Monitor{}
+	m.SetProbe(5*time.Millisecond, sp1.probe)
+	waitFor(t, time.Second, func() bool { return sp1.callCount() >= 1 })
+
+	m.SetProbe(5*time.Millisecond, sp2.probe)
+	waitFor(t, time.Second, func() bool { return sp2.callCount() >= 1 })
+
+	// sp1's goroutine should have stopped, so its call count should no longer be advancing.
+	before := sp1.callCount()
+	time.Sleep(20 * time.Millisecond)
+	assertEqual(t, before, sp1.callCount())
+
+	m.Stop()
+}
+
+func TestTCPProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	probe := TCPProbe(ln.Addr().String())
+	if err := probe(context.Background()); err != nil {
+		t.Fatalf("expected a probe against a listening address to succeed, got %v", err)
+	}
+
+	ln.Close()
+	if err := probe(context.Background()); err == nil {
+		t.Fatal("expected a probe against a closed listener to fail")
+	}
+}
+
+func TestPingProbe(t *testing.T) {
+	boom := errors.New("boom")
+	ok := PingProbe(func() error { return nil })
+	failing := PingProbe(func() error { return boom })
+
+	if err := ok(context.Background()); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := failing(context.Background()); !errors.Is(err, boom) {
+		t.Fatalf("expected %v, got %v", boom, err)
+	}
+}
@@ -0,0 +1,179 @@
+package nested
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// A ServiceFactory constructs a fresh instance of a supervised service.  It is called once when a Supervisor is
+// created and again every time the previous instance needs to be replaced after a failure.
+type ServiceFactory func() Service
+
+// A Supervisor watches a Service built by a ServiceFactory and, when the service enters the Error state, tears it
+// down and replaces it with a freshly constructed instance.  It is modelled on the supervision strategy used by the
+// Suture library: repeated failures are tracked as an exponentially decaying rate, and once that rate crosses
+// FailureThreshold the Supervisor pauses for FailureBackoff before restarting, rather than restarting in a tight
+// loop.
+//
+// Because a Monitor can never leave the Stopped state (see Monitor.setState), a Supervisor cannot simply reset the
+// Monitor embedded in a failed child and reuse it; instead, every restart discards the old instance and asks the
+// factory for a brand new one.
+//
+// A Supervisor implements the Service interface, so it can be nested inside a Collection like any other service.
+// Its ErrCount reflects the number of child failures that triggered a restart, rather than the number of
+// consecutive errors reported by a single child.
+//
+// A Supervisor must not be copied after first use.
+type Supervisor struct {
+	Monitor
+	mu      sync.Mutex
+	factory ServiceFactory
+	child   Service
+	token   Token
+
+	restarts int
+	failures float64
+	lastFail time.Time
+
+	// FailureThreshold is the failure rate above which the Supervisor backs off before restarting.  Defaults to 5.
+	FailureThreshold float64
+	// FailureDecay is the half-life, in seconds, of the failure rate.  A crash shortly after the
+	// previous one barely decays the accumulated rate; one long after decays it almost completely.  Defaults to 30.
+	FailureDecay float64
+	// FailureBackoff is how long the Supervisor waits before restarting once FailureThreshold has been exceeded.
+	// Defaults to 15s.
+	FailureBackoff time.Duration
+	// MaxRestarts caps the number of times the Supervisor will restart its child before giving up and transitioning
+	// to Stopped.  Zero (the default) means unlimited restarts.
+	MaxRestarts int
+}
+
+// Verifies that a Supervisor implements the Service interface.
+var _ Service = &Supervisor{}
+
+// NewSupervisor creates a Supervisor that builds its child services using factory and starts the first one
+// immediately.
+func NewSupervisor(factory ServiceFactory) *Supervisor {
+	s := &Supervisor{
+		factory:          factory,
+		FailureThreshold: 5,
+		FailureDecay:     30,
+		FailureBackoff:   15 * time.Second,
+	}
+	s.spawn()
+	return s
+}
+
+// ErrCount returns the number of child failures that have triggered a restart, including a final failure after
+// which the Supervisor gave up because MaxRestarts was exceeded.
+func (s *Supervisor) ErrCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.restarts
+}
+
+// Stop stops the Supervisor and its current child service.  No further restarts are attempted afterwards.
+func (s *Supervisor) Stop() {
+	s.mu.Lock()
+	child, token := s.child, s.token
+	s.mu.Unlock()
+
+	if child != nil {
+		child.DeregisterCallback(token)
+		child.Stop()
+	}
+	s.Monitor.Stop()
+}
+
+// StopContext stops the Supervisor and its current child service, like Stop, but returns ctx.Err() if they haven't
+// finished stopping before ctx is done.
+func (s *Supervisor) StopContext(ctx context.Context) error {
+	return WaitForStop(ctx, s.Stop)
+}
+
+// spawn constructs a new child from the factory and starts tracking its state.
+func (s *Supervisor) spawn() {
+	child := s.factory()
+
+	s.mu.Lock()
+	s.child = child
+	s.token = child.RegisterCallback(s.onChildEvent)
+	s.mu.Unlock()
+
+	// The factory may hand back a service that is already ready or already erroring; pick up its current state
+	// since we'll only be notified of transitions from here on.
+	switch child.GetState() {
+	case Ready:
+		s.Monitor.SetReady()
+	case Error:
+		s.onChildEvent(Event{NewState: Error, Error: child.Err()})
+	}
+}
+
+// onChildEvent is registered as the callback on whichever child is currently live.  It runs inside the child's own
+// Monitor.setState, which blocks the caller that triggered the transition (e.g. the child's own SetError) until
+// every registered callback returns.  So onChildEvent itself must return quickly; the teardown/backoff/respawn
+// sequence is handed off to restart, which runs in its own goroutine.
+func (s *Supervisor) onChildEvent(ev Event) {
+	switch ev.NewState {
+	case Ready:
+		s.Monitor.SetReady()
+	case Error:
+		if s.Monitor.GetState() == Stopped {
+			return
+		}
+		s.Monitor.SetError(ev.Error)
+		// A child already in Error can call SetError again on every subsequent failure (that's how its own ErrCount
+		// ticks up, and exactly what SetProbe's debounced probe loop does on every failing tick). Only the edge into
+		// Error should trigger a restart; otherwise a single failure episode spawns one restart() per repeated
+		// notification, each tearing down and replacing the child independently of the others.
+		if ev.OldState != Error {
+			go s.restart()
+		}
+	case Stopped:
+		// The child stopped on its own rather than being torn down for a restart; treat that as terminal.
+		s.Monitor.Stop()
+	}
+}
+
+// restart tears down the failed child, updates the failure rate, backs off if it has crossed FailureThreshold, and
+// spawns a replacement unless MaxRestarts has been exhausted or the Supervisor has since been stopped.
+func (s *Supervisor) restart() {
+	s.mu.Lock()
+	child, token := s.child, s.token
+	s.restarts++
+	restarts := s.restarts
+
+	now := time.Now()
+	if !s.lastFail.IsZero() {
+		s.failures *= math.Exp2(-now.Sub(s.lastFail).Seconds() / s.FailureDecay)
+	}
+	s.failures++
+	s.lastFail = now
+	backoff := s.failures > s.FailureThreshold
+	if backoff {
+		s.failures = 0
+	}
+	s.mu.Unlock()
+
+	child.DeregisterCallback(token)
+	child.Stop()
+
+	if s.MaxRestarts > 0 && restarts > s.MaxRestarts {
+		s.Monitor.Stop()
+		return
+	}
+
+	if backoff {
+		time.Sleep(s.FailureBackoff)
+	}
+
+	// Don't restart if Stop() was called while we were tearing down the old child or backing off.
+	if s.Monitor.GetState() == Stopped {
+		return
+	}
+
+	s.spawn()
+}